@@ -0,0 +1,277 @@
+// Package cgroups provides a small Manager for freezing, inspecting and
+// tearing down the cgroup backing a single container, similar in spirit to
+// what runc/libcontainer offers for its own containers.
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kinvolk/inspektor-gadget/pkg/gadgettracermanager/containerutils"
+)
+
+// FreezerState mirrors the values accepted by freezer.state (v1); on v2,
+// Freeze writes them to cgroup.freeze as "1" (Frozen) or "0" (otherwise).
+type FreezerState string
+
+const (
+	Frozen FreezerState = "FROZEN"
+	Thawed FreezerState = "THAWED"
+)
+
+// Stats is a best-effort snapshot of resource usage for a cgroup. Fields
+// are left at 0 when the backing controller isn't mounted or its file
+// can't be parsed.
+type Stats struct {
+	MemoryUsageBytes uint64
+	CPUUsageUsec     uint64
+	PidsCurrent      uint64
+	IOServiceBytes   uint64
+}
+
+// Manager controls the cgroup backing a single container, regardless of
+// whether the host runs unified (v2) or hybrid (v1) hierarchies.
+type Manager interface {
+	// Freeze writes the freezer state, pausing or resuming every process
+	// in the cgroup.
+	Freeze(state FreezerState) error
+	// Stats returns a snapshot of memory/cpu/pids/io usage.
+	Stats() (*Stats, error)
+	// Destroy removes the cgroup directory (or directories, on v1).
+	Destroy() error
+}
+
+type manager struct {
+	unified bool
+	// explicit is true when the Manager was built from a caller-supplied
+	// path rather than a PID; in that case path is used as-is instead of
+	// being joined under each v1 controller's mountpoint.
+	explicit bool
+	relPath  string
+	path     string
+}
+
+// NewManagerFromPID builds a Manager for the cgroup(s) of the given PID,
+// using containerutils.GetCgroupPaths to locate them.
+func NewManagerFromPID(pid int) (Manager, error) {
+	cgroupPathV1, cgroupPathV2, err := containerutils.GetCgroupPaths(pid)
+	if err != nil {
+		return nil, err
+	}
+	if cgroupPathV1 == "" && cgroupPathV2 == "" {
+		return nil, fmt.Errorf("pid %d has no cgroup path", pid)
+	}
+
+	m := &manager{relPath: cgroupPathV1}
+
+	if cgroupPathV2 != "" {
+		path, err := containerutils.CgroupPathV2AddMountpoint(cgroupPathV2)
+		if err != nil {
+			return nil, err
+		}
+		m.path = path
+		m.unified = hasDelegatedControllers(path)
+	}
+
+	return m, nil
+}
+
+// NewManagerFromPath builds a Manager directly from an already-mounted
+// cgroup path. The path is treated as a cgroup v2 directory with delegated
+// controllers when it lists any in "cgroup.controllers", and as a single
+// cgroup v1 controller directory otherwise.
+func NewManagerFromPath(path string) (Manager, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cannot access cgroup %q: %w", path, err)
+	}
+	if hasDelegatedControllers(path) {
+		return &manager{unified: true, path: path}, nil
+	}
+	return &manager{explicit: true, path: path}, nil
+}
+
+// controllerPath returns the mounted path of the given cgroup v1
+// controller for this manager's cgroup.
+func (m *manager) controllerPath(controller string) string {
+	if m.explicit {
+		return m.path
+	}
+	return filepath.Join("/sys/fs/cgroup", controller, m.relPath)
+}
+
+func (m *manager) Freeze(state FreezerState) error {
+	if m.unified {
+		value := "0"
+		if state == Frozen {
+			value = "1"
+		}
+		return os.WriteFile(filepath.Join(m.path, "cgroup.freeze"), []byte(value), 0o644)
+	}
+	return os.WriteFile(filepath.Join(m.controllerPath("freezer"), "freezer.state"), []byte(state), 0o644)
+}
+
+func (m *manager) Destroy() error {
+	if m.unified || m.explicit {
+		return os.Remove(m.path)
+	}
+	for _, controller := range []string{"memory", "cpu,cpuacct", "pids", "blkio", "freezer"} {
+		if err := os.Remove(m.controllerPath(controller)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *manager) Stats() (*Stats, error) {
+	if m.unified {
+		return m.statsV2(), nil
+	}
+	return m.statsV1(), nil
+}
+
+func (m *manager) statsV2() *Stats {
+	stats := &Stats{}
+
+	if v, err := readUint64File(filepath.Join(m.path, "memory.current")); err == nil {
+		stats.MemoryUsageBytes = v
+	}
+	if v, err := readKeyedUint64File(filepath.Join(m.path, "cpu.stat"), "usage_usec"); err == nil {
+		stats.CPUUsageUsec = v
+	}
+	if v, err := readUint64File(filepath.Join(m.path, "pids.current")); err == nil {
+		stats.PidsCurrent = v
+	}
+	if v, err := sumKeyedUint64File(filepath.Join(m.path, "io.stat"), "rbytes", "wbytes"); err == nil {
+		stats.IOServiceBytes = v
+	}
+
+	return stats
+}
+
+func (m *manager) statsV1() *Stats {
+	stats := &Stats{}
+
+	if v, err := readUint64File(filepath.Join(m.controllerPath("memory"), "memory.usage_in_bytes")); err == nil {
+		stats.MemoryUsageBytes = v
+	}
+	if v, err := readUint64File(filepath.Join(m.controllerPath("cpu,cpuacct"), "cpuacct.usage")); err == nil {
+		stats.CPUUsageUsec = v / 1000 // cpuacct.usage is in nanoseconds
+	}
+	if v, err := readUint64File(filepath.Join(m.controllerPath("pids"), "pids.current")); err == nil {
+		stats.PidsCurrent = v
+	}
+	if v, err := sumBlkioServiceBytes(filepath.Join(m.controllerPath("blkio"), "blkio.io_service_bytes_recursive")); err == nil {
+		stats.IOServiceBytes = v
+	}
+
+	return stats
+}
+
+// hasDelegatedControllers reports whether the cgroup v2 node at path has
+// any resource controller actually delegated to it.
+//
+// cgroup.freeze and other core interface files exist on every cgroup2
+// directory, including the systemd-only tracking hierarchy that a hybrid
+// host (real controllers still on cgroup v1) mounts alongside it - so
+// their mere presence can't tell unified from hybrid. cgroup.controllers
+// lists the controllers enabled for that node's children and is empty on
+// that tracking-only hierarchy, which is what we actually want to detect.
+func hasDelegatedControllers(path string) bool {
+	data, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return false
+	}
+	return len(strings.Fields(string(data))) > 0
+}
+
+func readUint64File(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyedUint64File parses "key value" lines such as cpu.stat and
+// returns the value for key.
+func readKeyedUint64File(path, key string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		return strconv.ParseUint(fields[1], 10, 64)
+	}
+	return 0, fmt.Errorf("key %q not found in %q", key, path)
+}
+
+// sumKeyedUint64File sums the named "key=value" fields across every line of
+// a file such as io.stat.
+func sumKeyedUint64File(path string, keys ...string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	wanted := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		wanted[k] = struct{}{}
+	}
+
+	var total uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if _, ok := wanted[parts[0]]; !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// sumBlkioServiceBytes sums the "Total" lines of a cgroup v1
+// blkio.io_service_bytes_recursive file.
+func sumBlkioServiceBytes(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var total uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "Total" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += v
+	}
+	return total, nil
+}
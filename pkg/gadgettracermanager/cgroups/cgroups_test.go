@@ -0,0 +1,160 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasDelegatedControllers(t *testing.T) {
+	unifiedDir := t.TempDir()
+	writeFile(t, filepath.Join(unifiedDir, "cgroup.controllers"), "cpu io memory pids\n")
+
+	hybridDir := t.TempDir()
+	writeFile(t, filepath.Join(hybridDir, "cgroup.controllers"), "\n")
+
+	if !hasDelegatedControllers(unifiedDir) {
+		t.Error("expected a dir listing controllers to be detected as delegated")
+	}
+	if hasDelegatedControllers(hybridDir) {
+		t.Error("expected a dir with an empty cgroup.controllers to be detected as not delegated")
+	}
+}
+
+func TestNewManagerFromPathUnified(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cgroup.controllers"), "cpu io memory pids\n")
+	writeFile(t, filepath.Join(dir, "cgroup.freeze"), "0\n")
+	writeFile(t, filepath.Join(dir, "memory.current"), "1048576\n")
+	writeFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 500000\nuser_usec 400000\n")
+	writeFile(t, filepath.Join(dir, "pids.current"), "3\n")
+	writeFile(t, filepath.Join(dir, "io.stat"), "8:0 rbytes=100 wbytes=200 rios=1 wios=1\n")
+
+	m, err := NewManagerFromPath(dir)
+	if err != nil {
+		t.Fatalf("NewManagerFromPath: %v", err)
+	}
+	if mgr := m.(*manager); !mgr.unified {
+		t.Fatalf("expected manager to be detected as unified, got %+v", mgr)
+	}
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.MemoryUsageBytes != 1048576 {
+		t.Errorf("MemoryUsageBytes = %d, want 1048576", stats.MemoryUsageBytes)
+	}
+	if stats.CPUUsageUsec != 500000 {
+		t.Errorf("CPUUsageUsec = %d, want 500000", stats.CPUUsageUsec)
+	}
+	if stats.PidsCurrent != 3 {
+		t.Errorf("PidsCurrent = %d, want 3", stats.PidsCurrent)
+	}
+	if stats.IOServiceBytes != 300 {
+		t.Errorf("IOServiceBytes = %d, want 300", stats.IOServiceBytes)
+	}
+
+	if err := m.Freeze(Frozen); err != nil {
+		t.Fatalf("Freeze: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.freeze"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("cgroup.freeze = %q, want \"1\"", data)
+	}
+}
+
+// TestNewManagerFromPathHybridTrackingOnly reproduces a hybrid host where
+// systemd's own cgroup v2 mount has no delegated controllers: real
+// resource accounting still lives on cgroup v1. The Manager must not be
+// treated as unified just because core v2 files like cgroup.freeze exist
+// there too (see chunk0-5 review).
+func TestNewManagerFromPathHybridTrackingOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cgroup.controllers"), "\n")
+	writeFile(t, filepath.Join(dir, "cgroup.freeze"), "0\n")
+	// v1 controller files, colocated here only to keep the fixture
+	// self-contained; controllerPath() serves them all from the same
+	// directory for an explicit (non-PID-derived) Manager.
+	writeFile(t, filepath.Join(dir, "memory.usage_in_bytes"), "2097152\n")
+	writeFile(t, filepath.Join(dir, "cpuacct.usage"), "1000000\n")
+	writeFile(t, filepath.Join(dir, "pids.current"), "5\n")
+	writeFile(t, filepath.Join(dir, "blkio.io_service_bytes_recursive"), "Total 4096\n")
+	writeFile(t, filepath.Join(dir, "freezer.state"), "THAWED\n")
+
+	m, err := NewManagerFromPath(dir)
+	if err != nil {
+		t.Fatalf("NewManagerFromPath: %v", err)
+	}
+	if mgr := m.(*manager); mgr.unified {
+		t.Fatalf("expected manager to be detected as hybrid (not unified), got %+v", mgr)
+	}
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.MemoryUsageBytes != 2097152 {
+		t.Errorf("MemoryUsageBytes = %d, want 2097152", stats.MemoryUsageBytes)
+	}
+	if stats.CPUUsageUsec != 1000 {
+		t.Errorf("CPUUsageUsec = %d, want 1000", stats.CPUUsageUsec)
+	}
+	if stats.PidsCurrent != 5 {
+		t.Errorf("PidsCurrent = %d, want 5", stats.PidsCurrent)
+	}
+	if stats.IOServiceBytes != 4096 {
+		t.Errorf("IOServiceBytes = %d, want 4096", stats.IOServiceBytes)
+	}
+
+	if err := m.Freeze(Frozen); err != nil {
+		t.Fatalf("Freeze: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "freezer.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != string(Frozen) {
+		t.Errorf("freezer.state = %q, want %q", data, Frozen)
+	}
+}
+
+func TestManagerDestroy(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	writeFile(t, filepath.Join(sub, "cgroup.controllers"), "cpu memory pids io\n")
+	writeFile(t, filepath.Join(sub, "cgroup.freeze"), "0\n")
+
+	m, err := NewManagerFromPath(sub)
+	if err != nil {
+		t.Fatalf("NewManagerFromPath: %v", err)
+	}
+
+	// A real cgroupfs directory holds no ordinary dirents - its interface
+	// files are synthesized by the kernel and don't block rmdir. Strip
+	// them here to keep this tmpfs-backed fixture honest about what
+	// Destroy actually has to do on a real cgroup.
+	os.Remove(filepath.Join(sub, "cgroup.controllers"))
+	os.Remove(filepath.Join(sub, "cgroup.freeze"))
+
+	if err := m.Destroy(); err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	if _, err := os.Stat(sub); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed, stat err = %v", sub, err)
+	}
+}
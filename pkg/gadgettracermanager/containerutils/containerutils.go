@@ -3,72 +3,33 @@ package containerutils
 import (
 	"bufio"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
-	"unsafe"
 
 	ocispec "github.com/opencontainers/runtime-spec/specs-go"
 
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 )
 
-/*
-#define _GNU_SOURCE
-#include <stdlib.h>
-#include <stdio.h>
-#include <sys/types.h>
-#include <sys/stat.h>
-#include <fcntl.h>
-#include <stdint.h>
-
-struct cgid_file_handle
-{
-  //struct file_handle handle;
-  unsigned int handle_bytes;
-  int handle_type;
-  uint64_t cgid;
-};
-
-uint64_t get_cgroupid(char *path) {
-  struct cgid_file_handle *h;
-  int mount_id;
-  int err;
-  uint64_t ret;
-
-  h = malloc(sizeof(struct cgid_file_handle));
-  if (!h)
-    return 0;
-
-  h->handle_bytes = 8;
-  err = name_to_handle_at(AT_FDCWD, path, (struct file_handle *)h, &mount_id, 0);
-  if (err != 0)
-    return 0;
-
-  if (h->handle_bytes != 8)
-    return 0;
-
-  ret = h->cgid;
-  free(h);
-
-  return ret;
-}
-*/
-import "C"
-
 const (
 	CONTAINERD_DEFAULT_SOCKET_PATH  = "/run/containerd/containerd.sock"
 	CRIO_DEFAULT_SOCKET_PATH        = "/run/crio/crio.sock"
 	DOCKER_SHIM_DEFAULT_SOCKER_PATH = "/var/run/dockershim.sock"
+	DOCKER_DEFAULT_SOCKET_PATH      = "/var/run/docker.sock"
+	PODMAN_DEFAULT_SOCKET_PATH      = "/run/podman/podman.sock"
 )
 
 func CgroupPathV2AddMountpoint(path string) (string, error) {
@@ -82,15 +43,63 @@ func CgroupPathV2AddMountpoint(path string) (string, error) {
 	return pathWithMountpoint, nil
 }
 
-// GetCgroupID returns the cgroup2 ID of a path.
+// CgroupPathV1AddMountpoint does the same as CgroupPathV2AddMountpoint, but
+// for the cgroup v1 "name=systemd" hierarchy.
+func CgroupPathV1AddMountpoint(path string) (string, error) {
+	pathWithMountpoint := filepath.Join("/sys/fs/cgroup/systemd", path)
+	if _, err := os.Stat(pathWithMountpoint); os.IsNotExist(err) {
+		pathWithMountpoint = filepath.Join("/sys/fs/cgroup/name=systemd", path)
+		if _, err := os.Stat(pathWithMountpoint); os.IsNotExist(err) {
+			return "", fmt.Errorf("cannot access cgroup %q: %v", path, err)
+		}
+	}
+	return pathWithMountpoint, nil
+}
+
+// GetCgroupID returns the cgroup ID of a path, as reported by the kernel's
+// file handle for the cgroupfs filesystem.
 func GetCgroupID(pathWithMountpoint string) (uint64, error) {
-	cPathWithMountpoint := C.CString(pathWithMountpoint)
-	ret := uint64(C.get_cgroupid(cPathWithMountpoint))
-	C.free(unsafe.Pointer(cPathWithMountpoint))
-	if ret == 0 {
-		return 0, fmt.Errorf("GetCgroupID on %q failed", pathWithMountpoint)
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, pathWithMountpoint, 0)
+	if err != nil {
+		return 0, fmt.Errorf("GetCgroupID on %q failed: %w", pathWithMountpoint, err)
+	}
+
+	b := handle.Bytes()
+	if len(b) != 8 {
+		return 0, fmt.Errorf("GetCgroupID on %q failed: unexpected handle size %d", pathWithMountpoint, len(b))
 	}
-	return ret, nil
+
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// GetCgroupIDFromPID resolves both the cgroup v1 and cgroup v2 IDs of a
+// process in one call. Both v1id and v2id are best-effort: each is left at
+// 0 when its hierarchy can't be resolved for the process (e.g. v1id on a
+// cgroup v2-only host, or v2id when the mountpoint/handle lookup fails),
+// without failing the other.
+func GetCgroupIDFromPID(pid int) (v1id, v2id uint64, err error) {
+	cgroupPathV1, cgroupPathV2, err := GetCgroupPaths(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if cgroupPathV1 != "" {
+		if pathWithMountpoint, err := CgroupPathV1AddMountpoint(cgroupPathV1); err == nil {
+			if id, err := GetCgroupID(pathWithMountpoint); err == nil {
+				v1id = id
+			}
+		}
+	}
+
+	if cgroupPathV2 != "" {
+		if pathWithMountpoint, err := CgroupPathV2AddMountpoint(cgroupPathV2); err == nil {
+			if id, err := GetCgroupID(pathWithMountpoint); err == nil {
+				v2id = id
+			}
+		}
+	}
+
+	return v1id, v2id, nil
 }
 
 // GetCgroup2Path returns the cgroup1 and cgroup2 paths of a process.
@@ -148,29 +157,23 @@ func GetMntNs(pid int) (uint64, error) {
 	return stat.Ino, nil
 }
 
+// PidFromContainerId resolves the PID of a container using the default
+// RuntimeConfig. See PidFromContainerIdWithConfig to target non-standard
+// socket locations, e.g. under a HostRoot or in rootless/k3s/MicroK8s
+// environments.
 func PidFromContainerId(containerID string) (int, error) {
+	return PidFromContainerIdWithConfig(containerID, DefaultRuntimeConfig())
+}
+
+// PidFromContainerIdWithConfig is PidFromContainerId, but resolving
+// runtime sockets through config instead of the compile-time defaults.
+func PidFromContainerIdWithConfig(containerID string, config *RuntimeConfig) (int, error) {
 	if strings.HasPrefix(containerID, "docker://") {
-		out, err := exec.Command("chroot", "/host", "docker", "inspect", strings.TrimPrefix(containerID, "docker://")).Output()
-		if err != nil {
-			return -1, err
-		}
-		type DockerInspect struct {
-			State struct {
-				Pid int
-			}
-		}
-		var dockerInspect []DockerInspect
-		err = json.Unmarshal(out, &dockerInspect)
-		if err != nil {
-			return -1, err
-		}
-		if len(dockerInspect) != 1 {
-			return -1, fmt.Errorf("invalid output")
-		}
-		return dockerInspect[0].State.Pid, nil
+		IDWithoutPrefix := strings.TrimPrefix(containerID, "docker://")
+		return pidFromDocker(IDWithoutPrefix, config)
 	} else if strings.HasPrefix(containerID, "cri-o://") {
 		IDWithoutPrefix := strings.TrimPrefix(containerID, "cri-o://")
-		r, err := getContainerStatus(CRIO_DEFAULT_SOCKET_PATH, IDWithoutPrefix)
+		r, err := getContainerStatus(config.hostPath(config.CRIOSocketPath), IDWithoutPrefix, config.dialTimeout())
 		if err != nil {
 			return -1, err
 		}
@@ -187,7 +190,7 @@ func PidFromContainerId(containerID string) (int, error) {
 		return pid, nil
 	} else if strings.HasPrefix(containerID, "containerd://") {
 		IDWithoutPrefix := strings.TrimPrefix(containerID, "containerd://")
-		r, err := getContainerStatus(CONTAINERD_DEFAULT_SOCKET_PATH, IDWithoutPrefix)
+		r, err := getContainerStatus(config.hostPath(config.ContainerdSocketPath), IDWithoutPrefix, config.dialTimeout())
 		if err != nil {
 			return -1, err
 		}
@@ -205,12 +208,137 @@ func PidFromContainerId(containerID string) (int, error) {
 			return -1, fmt.Errorf("invalid pid")
 		}
 		return containerdInspect.Pid, nil
+	} else if strings.HasPrefix(containerID, "podman://") {
+		IDWithoutPrefix := strings.TrimPrefix(containerID, "podman://")
+		return pidFromPodman(IDWithoutPrefix, config)
 	}
 	return -1, fmt.Errorf("unknown container runtime: %s", containerID)
 }
 
-func getContainerStatus(sockPath string, containerdID string) (*pb.ContainerStatusResponse, error) {
-	conn, err := getConnection(sockPath)
+// pidFromDocker resolves the PID of a container by querying the Docker
+// Engine API directly over its socket, instead of shelling out to the
+// docker CLI. If the Docker socket isn't reachable, it falls back to the
+// dockershim's CRI endpoint.
+func pidFromDocker(containerID string, config *RuntimeConfig) (int, error) {
+	dockerSockPath := config.hostPath(config.DockerSocketPath)
+	pid, err := pidFromDockerSocket(dockerSockPath, containerID, config.dialTimeout())
+	if err == nil {
+		return pid, nil
+	}
+
+	r, err := getContainerStatus(config.hostPath(config.DockerShimSocketPath), containerID, config.dialTimeout())
+	if err != nil {
+		return -1, fmt.Errorf("cannot reach docker via %q or dockershim: %v", dockerSockPath, err)
+	}
+	pidStr, ok := r.Info["pid"]
+	if !ok {
+		return -1, fmt.Errorf("container status reply from runtime doesn't contain 'pid'")
+	}
+
+	pid, err = strconv.Atoi(pidStr)
+	if err != nil {
+		return -1, err
+	}
+
+	return pid, nil
+}
+
+// pidFromDockerSocket inspects a single container through the Docker Engine
+// API reachable on sockPath.
+func pidFromDockerSocket(sockPath, containerID string, timeout time.Duration) (int, error) {
+	body, err := httpGetOverUnixSocket(sockPath, "/containers/"+containerID+"/json", timeout)
+	if err != nil {
+		return -1, err
+	}
+
+	type DockerInspect struct {
+		State struct {
+			Pid int
+		}
+	}
+	var dockerInspect DockerInspect
+	if err := json.Unmarshal(body, &dockerInspect); err != nil {
+		return -1, err
+	}
+	if dockerInspect.State.Pid == 0 {
+		return -1, fmt.Errorf("invalid pid")
+	}
+	return dockerInspect.State.Pid, nil
+}
+
+// pidFromPodman resolves the PID of a container through Podman's libpod
+// REST API. If the socket doesn't expose that API (e.g. Podman was set up
+// to only serve a CRI endpoint), it falls back to getContainerStatus on the
+// same socket.
+func pidFromPodman(containerID string, config *RuntimeConfig) (int, error) {
+	sockPath := config.podmanSocketPath()
+
+	body, err := httpGetOverUnixSocket(sockPath, "/v1.0.0/libpod/containers/"+containerID+"/json", config.dialTimeout())
+	if err == nil {
+		type PodmanInspect struct {
+			State struct {
+				Pid int
+			}
+		}
+		var podmanInspect PodmanInspect
+		if err := json.Unmarshal(body, &podmanInspect); err != nil {
+			return -1, err
+		}
+		if podmanInspect.State.Pid == 0 {
+			return -1, fmt.Errorf("invalid pid")
+		}
+		return podmanInspect.State.Pid, nil
+	}
+
+	r, err := getContainerStatus(sockPath, containerID, config.dialTimeout())
+	if err != nil {
+		return -1, fmt.Errorf("cannot reach podman on %q via libpod API or CRI: %v", sockPath, err)
+	}
+	pidStr, ok := r.Info["pid"]
+	if !ok {
+		return -1, fmt.Errorf("container status reply from runtime doesn't contain 'pid'")
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return -1, err
+	}
+
+	return pid, nil
+}
+
+// httpGetOverUnixSocket performs an HTTP GET for urlPath against a daemon
+// listening on a unix socket, such as Docker's or Podman's.
+func httpGetOverUnixSocket(sockPath, urlPath string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", sockPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", "http://unix"+urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, urlPath)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func getContainerStatus(sockPath string, containerdID string, timeout time.Duration) (*pb.ContainerStatusResponse, error) {
+	conn, err := getConnection(sockPath, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -225,12 +353,12 @@ func getContainerStatus(sockPath string, containerdID string) (*pb.ContainerStat
 	return runtimeClient.ContainerStatus(context.Background(), request)
 }
 
-func getConnection(path string) (*grpc.ClientConn, error) {
+func getConnection(path string, timeout time.Duration) (*grpc.ClientConn, error) {
 	return grpc.Dial(
 		path,
 		grpc.WithInsecure(),
-		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
-			return net.DialTimeout("unix", path, 2*time.Second)
+		grpc.WithDialer(func(addr string, dialTimeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", path, timeout)
 		}))
 }
 
@@ -0,0 +1,128 @@
+package containerutils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newUnixHTTPTestServer starts an httptest.Server listening on a unix
+// socket under a temporary directory, so the docker/podman clients (which
+// only know how to dial unix sockets) can be exercised without touching
+// the real host.
+func newUnixHTTPTestServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listening on %q: %v", sockPath, err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return sockPath
+}
+
+func TestPidFromDockerSocket(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/abc123/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"State":{"Pid":4242}}`)
+	})
+	sockPath := newUnixHTTPTestServer(t, mux)
+
+	pid, err := pidFromDockerSocket(sockPath, "abc123", time.Second)
+	if err != nil {
+		t.Fatalf("pidFromDockerSocket: %v", err)
+	}
+	if pid != 4242 {
+		t.Errorf("pid = %d, want 4242", pid)
+	}
+}
+
+func TestPidFromDockerSocketInvalidPid(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/abc123/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"State":{"Pid":0}}`)
+	})
+	sockPath := newUnixHTTPTestServer(t, mux)
+
+	if _, err := pidFromDockerSocket(sockPath, "abc123", time.Second); err == nil {
+		t.Fatal("expected an error for a zero pid")
+	}
+}
+
+func TestPidFromPodmanLibpod(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.0.0/libpod/containers/abc123/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"State":{"Pid":9001}}`)
+	})
+	sockPath := newUnixHTTPTestServer(t, mux)
+
+	pid, err := pidFromPodman("abc123", &RuntimeConfig{PodmanSocketPath: sockPath, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("pidFromPodman: %v", err)
+	}
+	if pid != 9001 {
+		t.Errorf("pid = %d, want 9001", pid)
+	}
+}
+
+func TestHttpGetOverUnixSocketNonOKStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/missing/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	sockPath := newUnixHTTPTestServer(t, mux)
+
+	if _, err := httpGetOverUnixSocket(sockPath, "/containers/missing/json", time.Second); err == nil {
+		t.Fatal("expected an error for a non-200 status")
+	}
+}
+
+func TestAutodetectRuntimes(t *testing.T) {
+	hostRoot := t.TempDir()
+
+	touch := func(relPath string) string {
+		path := filepath.Join(hostRoot, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	containerdPath := touch(CONTAINERD_DEFAULT_SOCKET_PATH)
+	dockerPath := touch(DOCKER_DEFAULT_SOCKET_PATH)
+
+	found := AutodetectRuntimes(hostRoot)
+
+	got := make(map[string]string, len(found))
+	for _, endpoint := range found {
+		got[endpoint.Runtime] = endpoint.SocketPath
+	}
+
+	want := map[string]string{
+		"containerd": containerdPath,
+		"docker":     dockerPath,
+	}
+	for runtime, path := range want {
+		if got[runtime] != path {
+			t.Errorf("runtime %q: got %q, want %q", runtime, got[runtime], path)
+		}
+	}
+	if len(found) != len(want) {
+		t.Errorf("found %d endpoints, want %d: %+v", len(found), len(want), found)
+	}
+}
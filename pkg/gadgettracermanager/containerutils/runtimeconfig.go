@@ -0,0 +1,107 @@
+package containerutils
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RuntimeConfig gathers everything PidFromContainerId and the Watcher need
+// to know about the host's container runtimes, instead of relying on the
+// compile-time *_DEFAULT_SOCKET_PATH constants.
+type RuntimeConfig struct {
+	// HostRoot is prefixed to every socket path below, analogous to the
+	// /host mount point gadgets run under. Leave empty when running
+	// directly on the host.
+	HostRoot string
+
+	ContainerdSocketPath string
+	CRIOSocketPath       string
+	DockerShimSocketPath string
+	DockerSocketPath     string
+	PodmanSocketPath     string
+
+	// DialTimeout bounds every dial to a runtime socket, CRI or REST.
+	DialTimeout time.Duration
+}
+
+// DefaultRuntimeConfig returns a RuntimeConfig using the well-known socket
+// paths, unprefixed, with a 2 second dial timeout.
+func DefaultRuntimeConfig() *RuntimeConfig {
+	return &RuntimeConfig{
+		ContainerdSocketPath: CONTAINERD_DEFAULT_SOCKET_PATH,
+		CRIOSocketPath:       CRIO_DEFAULT_SOCKET_PATH,
+		DockerShimSocketPath: DOCKER_SHIM_DEFAULT_SOCKER_PATH,
+		DockerSocketPath:     DOCKER_DEFAULT_SOCKET_PATH,
+		PodmanSocketPath:     PODMAN_DEFAULT_SOCKET_PATH,
+		DialTimeout:          2 * time.Second,
+	}
+}
+
+// hostPath prefixes path with c.HostRoot, if set.
+func (c *RuntimeConfig) hostPath(path string) string {
+	if c.HostRoot == "" || path == "" {
+		return path
+	}
+	return filepath.Join(c.HostRoot, path)
+}
+
+func (c *RuntimeConfig) dialTimeout() time.Duration {
+	if c.DialTimeout == 0 {
+		return 2 * time.Second
+	}
+	return c.DialTimeout
+}
+
+// podmanSocketPath resolves the Podman socket to use, preferring the
+// configured system socket and falling back to the rootless one under
+// $XDG_RUNTIME_DIR when it isn't present.
+func (c *RuntimeConfig) podmanSocketPath() string {
+	sockPath := c.hostPath(c.PodmanSocketPath)
+	if _, err := os.Stat(sockPath); err == nil {
+		return sockPath
+	}
+	if xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR"); xdgRuntimeDir != "" {
+		return c.hostPath(filepath.Join(xdgRuntimeDir, "podman", "podman.sock"))
+	}
+	return sockPath
+}
+
+// RuntimeEndpoint is a live socket found by AutodetectRuntimes.
+type RuntimeEndpoint struct {
+	Runtime    string // "containerd", "cri-o", "docker" or "podman"
+	SocketPath string
+}
+
+// AutodetectRuntimes stats the common runtime socket locations - including
+// rootless, k3s and MicroK8s paths - and returns the set of ones that
+// exist. hostRoot is prefixed to every candidate path, as with
+// RuntimeConfig.HostRoot.
+func AutodetectRuntimes(hostRoot string) []RuntimeEndpoint {
+	candidates := []RuntimeEndpoint{
+		{"containerd", CONTAINERD_DEFAULT_SOCKET_PATH},
+		{"containerd", "/run/k3s/containerd/containerd.sock"},
+		{"containerd", "/var/snap/microk8s/common/run/containerd.sock"},
+		{"cri-o", CRIO_DEFAULT_SOCKET_PATH},
+		{"docker", DOCKER_DEFAULT_SOCKET_PATH},
+		{"docker", DOCKER_SHIM_DEFAULT_SOCKER_PATH},
+		{"podman", PODMAN_DEFAULT_SOCKET_PATH},
+	}
+
+	if xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR"); xdgRuntimeDir != "" {
+		candidates = append(candidates, RuntimeEndpoint{"podman", filepath.Join(xdgRuntimeDir, "podman", "podman.sock")})
+	}
+
+	var found []RuntimeEndpoint
+	for _, candidate := range candidates {
+		path := candidate.SocketPath
+		if hostRoot != "" {
+			path = filepath.Join(hostRoot, path)
+		}
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, RuntimeEndpoint{Runtime: candidate.Runtime, SocketPath: path})
+		}
+	}
+
+	return found
+}
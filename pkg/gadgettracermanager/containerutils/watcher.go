@@ -0,0 +1,371 @@
+package containerutils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/runtime-spec/specs-go"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// ContainerEventType describes a container lifecycle transition reported by
+// a Watcher.
+type ContainerEventType string
+
+const (
+	EventTypeAddContainer    ContainerEventType = "add"
+	EventTypeRemoveContainer ContainerEventType = "remove"
+)
+
+// ContainerEvent is the runtime-agnostic shape emitted by a Watcher. It
+// carries everything a gadget needs to install per-cgroup eBPF filters
+// without polling /proc itself.
+type ContainerEvent struct {
+	Type         ContainerEventType
+	ContainerID  string
+	Pid          int
+	CgroupV1Path string
+	CgroupV2Path string
+	CgroupID     uint64
+	MntNs        uint64
+	OCIState     *ocispec.State
+}
+
+// Watcher subscribes to container lifecycle events from whichever runtime
+// is available and reports them as ContainerEvents through a single
+// callback.
+//
+// The CRI socket is always polled, since it is reachable for every
+// supported runtime (containerd, cri-o, and docker via dockershim).
+// DockerSocketPath, when resolved by NewWatcher, additionally tails the
+// Docker Engine /events endpoint directly, which reports container
+// transitions without waiting for the next poll tick.
+type Watcher struct {
+	Config *RuntimeConfig
+
+	CRISocketPath    string
+	DockerSocketPath string
+	PollInterval     time.Duration
+
+	eventCallback func(ContainerEvent)
+
+	mu         sync.Mutex
+	containers map[string]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWatcher creates a Watcher that reports events to eventCallback, using
+// config to resolve runtime sockets (DefaultRuntimeConfig() if nil).
+// criRuntime picks which of config's CRI-speaking sockets to poll:
+// "containerd", "cri-o" or "dockershim"; it defaults to "cri-o".
+// watchDockerEvents additionally resolves config.DockerSocketPath and wires
+// it up so Start also tails the Docker Engine /events endpoint.
+func NewWatcher(config *RuntimeConfig, criRuntime string, watchDockerEvents bool, eventCallback func(ContainerEvent)) *Watcher {
+	if config == nil {
+		config = DefaultRuntimeConfig()
+	}
+
+	criSocketPath := config.hostPath(config.CRIOSocketPath)
+	switch criRuntime {
+	case "containerd":
+		criSocketPath = config.hostPath(config.ContainerdSocketPath)
+	case "dockershim":
+		criSocketPath = config.hostPath(config.DockerShimSocketPath)
+	}
+
+	dockerSocketPath := ""
+	if watchDockerEvents {
+		dockerSocketPath = config.hostPath(config.DockerSocketPath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Watcher{
+		Config:           config,
+		CRISocketPath:    criSocketPath,
+		DockerSocketPath: dockerSocketPath,
+		PollInterval:     2 * time.Second,
+		eventCallback:    eventCallback,
+		containers:       make(map[string]struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+}
+
+// Start begins watching in the background. It returns once the CRI socket
+// has been dialed and probed with a ListContainers call, so a missing
+// socket or unreachable runtime is reported here rather than silently
+// producing a watcher that never delivers events; events are delivered
+// asynchronously to the callback passed to NewWatcher.
+func (w *Watcher) Start() error {
+	conn, err := getConnection(w.CRISocketPath, w.Config.dialTimeout())
+	if err != nil {
+		return fmt.Errorf("connecting to %q: %w", w.CRISocketPath, err)
+	}
+	runtimeClient := pb.NewRuntimeServiceClient(conn)
+
+	if _, err := runtimeClient.ListContainers(w.ctx, &pb.ListContainersRequest{}); err != nil {
+		return fmt.Errorf("probing CRI socket %q: %w", w.CRISocketPath, err)
+	}
+
+	w.wg.Add(1)
+	go w.pollLoop(runtimeClient)
+
+	if w.DockerSocketPath != "" {
+		w.wg.Add(1)
+		go w.watchDockerEvents()
+	}
+
+	return nil
+}
+
+// Stop terminates the watcher and waits for its goroutines to exit.
+func (w *Watcher) Stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// pollLoop periodically lists containers known to the CRI runtime and diffs
+// them against what was already seen.
+//
+// v1alpha2 has no GetContainerEvents RPC, hence the polling; once the
+// cluster's CRI socket is upgraded to v1, this can be replaced by a single
+// streaming call without changing ContainerEvent or the callback contract.
+func (w *Watcher) pollLoop(runtimeClient pb.RuntimeServiceClient) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	w.pollOnce(runtimeClient)
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.pollOnce(runtimeClient)
+		}
+	}
+}
+
+func (w *Watcher) pollOnce(runtimeClient pb.RuntimeServiceClient) {
+	resp, err := runtimeClient.ListContainers(w.ctx, &pb.ListContainersRequest{})
+	if err != nil {
+		log.Printf("containerutils: listing containers on CRI socket %q: %v", w.CRISocketPath, err)
+		return
+	}
+
+	seen := make(map[string]struct{}, len(resp.Containers))
+
+	w.mu.Lock()
+	for _, c := range resp.Containers {
+		seen[c.Id] = struct{}{}
+		if _, known := w.containers[c.Id]; known {
+			continue
+		}
+		w.containers[c.Id] = struct{}{}
+		w.mu.Unlock()
+
+		if event, err := w.buildCRIEvent(EventTypeAddContainer, c.Id); err == nil {
+			w.eventCallback(event)
+		}
+
+		w.mu.Lock()
+	}
+
+	for id := range w.containers {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		delete(w.containers, id)
+		w.eventCallback(ContainerEvent{Type: EventTypeRemoveContainer, ContainerID: id})
+	}
+	w.mu.Unlock()
+}
+
+// buildCRIEvent enriches a container ID into a full ContainerEvent using
+// the CRI ContainerStatus call together with the existing cgroup/mntns
+// helpers.
+func (w *Watcher) buildCRIEvent(eventType ContainerEventType, containerID string) (ContainerEvent, error) {
+	r, err := getContainerStatus(w.CRISocketPath, containerID, w.Config.dialTimeout())
+	if err != nil {
+		return ContainerEvent{}, err
+	}
+
+	pid := 0
+	if pidStr, ok := r.Info["pid"]; ok {
+		if pid, err = strconv.Atoi(pidStr); err != nil {
+			return ContainerEvent{}, err
+		}
+	} else if info, ok := r.Info["info"]; ok {
+		// containerd reports the pid inside its verbose "info" JSON.
+		containerdInspect := struct{ Pid int }{}
+		if err := json.Unmarshal([]byte(info), &containerdInspect); err != nil {
+			return ContainerEvent{}, err
+		}
+		pid = containerdInspect.Pid
+	}
+	if pid == 0 {
+		return ContainerEvent{}, fmt.Errorf("container status reply from runtime doesn't contain a pid")
+	}
+
+	event := enrichEvent(eventType, containerID, pid)
+
+	if info, ok := r.Info["info"]; ok {
+		state := &ocispec.State{}
+		if err := json.Unmarshal([]byte(info), state); err == nil {
+			event.OCIState = state
+		}
+	}
+
+	return event, nil
+}
+
+// enrichEvent fills in the cgroup and mount namespace fields shared by
+// every runtime backend, given a PID already resolved for containerID.
+func enrichEvent(eventType ContainerEventType, containerID string, pid int) ContainerEvent {
+	event := ContainerEvent{
+		Type:        eventType,
+		ContainerID: containerID,
+		Pid:         pid,
+	}
+
+	if cgroupV1, cgroupV2, err := GetCgroupPaths(pid); err == nil {
+		event.CgroupV1Path = cgroupV1
+		event.CgroupV2Path = cgroupV2
+	}
+
+	if v1id, v2id, err := GetCgroupIDFromPID(pid); err == nil {
+		event.CgroupID = v2id
+		if event.CgroupID == 0 {
+			event.CgroupID = v1id
+		}
+	}
+
+	if mntNs, err := GetMntNs(pid); err == nil {
+		event.MntNs = mntNs
+	}
+
+	return event
+}
+
+// watchDockerEvents tails the Docker Engine /events endpoint, reconnecting
+// after waiting PollInterval if the stream is dropped. It shares w.containers with
+// pollOnce so a container reported here is not reported again once the
+// CRI poller catches up to it.
+func (w *Watcher) watchDockerEvents() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		default:
+		}
+
+		if err := w.streamDockerEvents(); err != nil {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-time.After(w.PollInterval):
+			}
+		}
+	}
+}
+
+func (w *Watcher) streamDockerEvents() error {
+	conn, err := net.DialTimeout("unix", w.DockerSocketPath, w.Config.dialTimeout())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// decoder.Decode below blocks on the unix socket read with no deadline,
+	// so closing conn is what unblocks it once w.ctx is canceled.
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-w.ctx.Done():
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	query := url.Values{}
+	query.Set("filters", `{"type":["container"]}`)
+	req, err := http.NewRequest("GET", "http://unix/events?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		if w.ctx.Err() != nil {
+			return nil
+		}
+
+		var msg struct {
+			ID     string
+			Action string
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+
+		switch msg.Action {
+		case "start":
+			w.mu.Lock()
+			_, known := w.containers[msg.ID]
+			w.mu.Unlock()
+			if known {
+				continue
+			}
+
+			pid, err := pidFromDockerSocket(w.DockerSocketPath, msg.ID, w.Config.dialTimeout())
+			if err != nil {
+				continue
+			}
+
+			w.mu.Lock()
+			if _, known := w.containers[msg.ID]; known {
+				w.mu.Unlock()
+				continue
+			}
+			w.containers[msg.ID] = struct{}{}
+			w.mu.Unlock()
+
+			w.eventCallback(enrichEvent(EventTypeAddContainer, msg.ID, pid))
+		case "die", "stop", "destroy":
+			w.mu.Lock()
+			_, known := w.containers[msg.ID]
+			delete(w.containers, msg.ID)
+			w.mu.Unlock()
+			if !known {
+				continue
+			}
+			w.eventCallback(ContainerEvent{Type: EventTypeRemoveContainer, ContainerID: msg.ID})
+		}
+	}
+}
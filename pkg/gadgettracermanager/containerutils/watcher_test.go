@@ -0,0 +1,166 @@
+package containerutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// fakeRuntimeServer is a minimal CRI RuntimeService backing a single
+// container, just enough for Watcher's ListContainers/ContainerStatus
+// calls.
+type fakeRuntimeServer struct {
+	pb.UnimplementedRuntimeServiceServer
+
+	containerID string
+	pid         int
+}
+
+func (f *fakeRuntimeServer) ListContainers(ctx context.Context, req *pb.ListContainersRequest) (*pb.ListContainersResponse, error) {
+	return &pb.ListContainersResponse{Containers: []*pb.Container{{Id: f.containerID}}}, nil
+}
+
+func (f *fakeRuntimeServer) ContainerStatus(ctx context.Context, req *pb.ContainerStatusRequest) (*pb.ContainerStatusResponse, error) {
+	return &pb.ContainerStatusResponse{
+		Status: &pb.ContainerStatus{Id: f.containerID},
+		Info:   map[string]string{"pid": fmt.Sprintf("%d", f.pid)},
+	}, nil
+}
+
+// newFakeCRIServer starts a CRI RuntimeService serving a single container on
+// a unix socket under a temporary directory.
+func newFakeCRIServer(t *testing.T, containerID string, pid int) string {
+	t.Helper()
+
+	sockPath := filepath.Join(t.TempDir(), "cri.sock")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listening on %q: %v", sockPath, err)
+	}
+
+	server := grpc.NewServer()
+	pb.RegisterRuntimeServiceServer(server, &fakeRuntimeServer{containerID: containerID, pid: pid})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return sockPath
+}
+
+// newFakeDockerEventsServer starts a unix-socket HTTP server that emits a
+// single "start" event for containerID on /events and resolves its pid on
+// /containers/<id>/json, like the real Docker Engine API.
+func newFakeDockerEventsServer(t *testing.T, containerID string, pid int) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(map[string]string{"ID": containerID, "Action": "start"}); err != nil {
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		<-r.Context().Done()
+	})
+	mux.HandleFunc(fmt.Sprintf("/containers/%s/json", containerID), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"State":{"Pid":%d}}`, pid)
+	})
+
+	return newUnixHTTPTestServer(t, mux)
+}
+
+// TestWatcherDedupesDockerAndCRIEvents starts a Watcher against a fake CRI
+// server and a fake Docker /events stream that both know about the same
+// container, and checks the container is only reported added once: the
+// CRI poller and the Docker event stream share w.containers precisely so
+// they don't each report the same transition independently.
+func TestWatcherDedupesDockerAndCRIEvents(t *testing.T) {
+	const containerID = "abc123"
+	pid := os.Getpid()
+
+	criSockPath := newFakeCRIServer(t, containerID, pid)
+	dockerSockPath := newFakeDockerEventsServer(t, containerID, pid)
+
+	var mu sync.Mutex
+	addCount := 0
+	callback := func(event ContainerEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Type == EventTypeAddContainer && event.ContainerID == containerID {
+			addCount++
+		}
+	}
+
+	config := &RuntimeConfig{
+		CRIOSocketPath:   criSockPath,
+		DockerSocketPath: dockerSockPath,
+		DialTimeout:      time.Second,
+	}
+	w := NewWatcher(config, "cri-o", true, callback)
+	w.PollInterval = 20 * time.Millisecond
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer w.Stop()
+
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if addCount != 1 {
+		t.Errorf("addCount = %d, want 1 (docker stream and CRI poller should dedupe the same container)", addCount)
+	}
+}
+
+// TestWatcherStopUnblocksOnQuietDockerStream checks that Stop returns
+// promptly even while streamDockerEvents is blocked reading from a Docker
+// /events stream that never sends anything after the initial connection.
+func TestWatcherStopUnblocksOnQuietDockerStream(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		<-r.Context().Done()
+	})
+	dockerSockPath := newUnixHTTPTestServer(t, mux)
+
+	criSockPath := newFakeCRIServer(t, "unused", os.Getpid())
+
+	config := &RuntimeConfig{
+		CRIOSocketPath:   criSockPath,
+		DockerSocketPath: dockerSockPath,
+		DialTimeout:      time.Second,
+	}
+	w := NewWatcher(config, "cri-o", true, func(ContainerEvent) {})
+	w.PollInterval = 20 * time.Millisecond
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return while streamDockerEvents was blocked reading a quiet stream")
+	}
+}